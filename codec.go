@@ -0,0 +1,166 @@
+package d3framework
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec marshals and unmarshals data for a single wire format. It is
+// advertised over HTTP via ContentType (matched against the Accept and
+// Content-Type headers) and over WebSocket via a subprotocol of the
+// same name.
+type Codec interface {
+	Marshal(data interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	ContentType() string
+}
+
+// JSONCodec is the framework's default codec.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(data interface{}) ([]byte, error)    { return json.Marshal(data) }
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error  { return json.Unmarshal(data, v) }
+func (JSONCodec) ContentType() string                         { return "application/json" }
+
+// ProtobufCodec marshals proto.Message values with the protobuf wire
+// format. Marshal and Unmarshal fail if the value doesn't implement
+// proto.Message.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Marshal(data interface{}) ([]byte, error) {
+	msg, ok := data.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T does not implement proto.Message", data)
+	}
+	return proto.Marshal(msg)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+func (ProtobufCodec) ContentType() string { return "application/x-protobuf" }
+
+// MsgpackCodec marshals with MessagePack.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(data interface{}) ([]byte, error)   { return msgpack.Marshal(data) }
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+func (MsgpackCodec) ContentType() string                        { return "application/x-msgpack" }
+
+// RegisterCodec makes codec available for content negotiation, keyed
+// by its ContentType.
+func (f *Framework) RegisterCodec(codec Codec) {
+	if f.codecs == nil {
+		f.codecs = make(map[string]Codec)
+	}
+	f.codecs[codec.ContentType()] = codec
+}
+
+// NegotiateCodec picks a registered Codec for r based on its Accept
+// header, falling back to Content-Type, and finally to JSONCodec if
+// neither matches a registered codec.
+func (f *Framework) NegotiateCodec(r *http.Request) Codec {
+	if accept := r.Header.Get("Accept"); accept != "" {
+		for _, part := range strings.Split(accept, ",") {
+			ct := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+			if codec, ok := f.codecs[ct]; ok {
+				return codec
+			}
+		}
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, err := mime.ParseMediaType(ct); err == nil {
+			if codec, ok := f.codecs[mediaType]; ok {
+				return codec
+			}
+		}
+	}
+	return f.defaultCodec()
+}
+
+func (f *Framework) defaultCodec() Codec {
+	if codec, ok := f.codecs[(JSONCodec{}).ContentType()]; ok {
+		return codec
+	}
+	return JSONCodec{}
+}
+
+// codecKey is the context key servePipeline and WebSocketServer use to
+// publish the negotiated Codec to Output handlers.
+type codecKey struct{}
+
+// CodecFromContext returns the Codec NegotiateCodec (or
+// NegotiateSubprotocol) chose for the current request, if any Output
+// handler wants to marshal with it directly instead of assuming JSON.
+func CodecFromContext(ctx context.Context) (Codec, bool) {
+	codec, ok := ctx.Value(codecKey{}).(Codec)
+	return codec, ok
+}
+
+// NegotiateSubprotocol picks a registered Codec whose ContentType
+// appears in offered, the WebSocket subprotocols a client listed in its
+// Sec-WebSocket-Protocol header, preferring whichever the client listed
+// first. It returns the default codec and an empty protocol name if
+// none of the offered subprotocols match a registered codec, so the
+// server's handshake response omits Sec-WebSocket-Protocol.
+func (f *Framework) NegotiateSubprotocol(offered []string) (Codec, string) {
+	for _, protocol := range offered {
+		if codec, ok := f.codecs[protocol]; ok {
+			return codec, protocol
+		}
+	}
+	return f.defaultCodec(), ""
+}
+
+// codecForProtocol returns the registered Codec matching the first of
+// protocols, falling back to the default codec if none match or none
+// were negotiated.
+func (f *Framework) codecForProtocol(protocols []string) Codec {
+	for _, protocol := range protocols {
+		if codec, ok := f.codecs[protocol]; ok {
+			return codec
+		}
+	}
+	return f.defaultCodec()
+}
+
+// WriteFrame writes payload as a length-prefixed frame: a uvarint
+// byte length followed by the payload itself, so multiple encoded
+// messages can safely share one TCP connection.
+func WriteFrame(w io.Writer, payload []byte) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(payload)))
+	if _, err := w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// ReadFrame reads one length-prefixed frame written by WriteFrame.
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"d3framework"
 	"fmt"
 	"net"
@@ -8,13 +9,12 @@ import (
 	"google.golang.org/grpc"
 	"golang.org/x/net/websocket"
 	"context"
-	"log"
 )
 
 // CustomDataHandler handles data fetching
 type CustomDataHandler struct{}
 
-func (d *CustomDataHandler) FetchData(params map[string]string) (interface{}, error) {
+func (d *CustomDataHandler) FetchData(ctx context.Context, params map[string]string) (interface{}, error) {
 	name := params["name"]
 	if name == "" {
 		name = "World"
@@ -35,12 +35,11 @@ func (i *CustomInputHandler) HandleHTTPInput(r *http.Request) (map[string]string
 }
 
 func (i *CustomInputHandler) HandleTCPInput(conn net.Conn) (map[string]string, error) {
-	buffer := make([]byte, 1024)
-	n, err := conn.Read(buffer)
+	payload, err := d3framework.ReadFrame(bufio.NewReader(conn))
 	if err != nil {
 		return nil, err
 	}
-	params := map[string]string{"message": string(buffer[:n])}
+	params := map[string]string{"message": string(payload)}
 	return params, nil
 }
 
@@ -57,18 +56,21 @@ type CustomOutputHandler struct {
 	d3framework.BaseOutputHandler
 }
 
-func (o *CustomOutputHandler) SendHTTPResponse(w http.ResponseWriter, data interface{}) {
+func (o *CustomOutputHandler) SendHTTPResponse(ctx context.Context, w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, data)
 }
 
-func (o *CustomOutputHandler) SendTCPResponse(conn net.Conn, data interface{}) error {
-	_, err := conn.Write([]byte(fmt.Sprintf("%v", data)))
-	return err
+func (o *CustomOutputHandler) SendTCPResponse(ctx context.Context, conn net.Conn, data interface{}) error {
+	payload, err := (d3framework.JSONCodec{}).Marshal(data)
+	if err != nil {
+		return err
+	}
+	return d3framework.WriteFrame(conn, payload)
 }
 
-func (o *CustomOutputHandler) SendWebSocketResponse(conn *websocket.Conn, data interface{}) error {
+func (o *CustomOutputHandler) SendWebSocketResponse(ctx context.Context, conn *websocket.Conn, data interface{}) error {
 	return websocket.Message.Send(conn, data)
 }
 
@@ -77,65 +79,34 @@ type CustomDeliveryHandler struct {
 	d3framework.BaseDeliveryHandler
 }
 
-func (d *CustomDeliveryHandler) ProcessData(data interface{}) (interface{}, error) {
+func (d *CustomDeliveryHandler) ProcessData(ctx context.Context, data interface{}) (interface{}, error) {
 	return fmt.Sprintf("Processed Data: %v", data), nil
 }
 
-func startHTTPServer(framework *d3framework.Framework) {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		params, _ := framework.Input.HandleHTTPInput(r)
-		data, _ := framework.Data.FetchData(params)
-		processedData, _ := framework.Delivery.ProcessData(data)
-		framework.Output.SendHTTPResponse(w, processedData)
-	})
-	fmt.Println("Starting HTTP server on :8080")
-	http.ListenAndServe(":8080", nil)
-}
-
-func startTCPServer(framework *d3framework.Framework) {
-	listener, err := net.Listen("tcp", ":8081")
-	if err != nil {
-		log.Fatalf("Failed to start TCP server: %v", err)
-	}
-	fmt.Println("Starting TCP server on :8081")
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Printf("Failed to accept connection: %v", err)
-			continue
-		}
-		go func(conn net.Conn) {
-			params, _ := framework.Input.HandleTCPInput(conn)
-			data, _ := framework.Data.FetchData(params)
-			processedData, _ := framework.Delivery.ProcessData(data)
-			framework.Output.SendTCPResponse(conn, processedData)
-			conn.Close()
-		}(conn)
-	}
-}
-
-func startWebSocketServer(framework *d3framework.Framework) {
-	http.Handle("/ws", websocket.Handler(func(conn *websocket.Conn) {
-		params, _ := framework.Input.HandleWebSocketInput(conn)
-		data, _ := framework.Data.FetchData(params)
-		processedData, _ := framework.Delivery.ProcessData(data)
-		framework.Output.SendWebSocketResponse(conn, processedData)
-	}))
-	fmt.Println("Starting WebSocket server on :8082")
-	http.ListenAndServe(":8082", nil)
-}
-
 func main() {
 	framework := &d3framework.Framework{
-		Data:    &CustomDataHandler{},
-		Input:   &CustomInputHandler{},
-		Output:  &CustomOutputHandler{},
+		Data:     &CustomDataHandler{},
+		Input:    &CustomInputHandler{},
+		Output:   &CustomOutputHandler{},
 		Delivery: &CustomDeliveryHandler{},
 	}
 
-	go startHTTPServer(framework)
-	go startTCPServer(framework)
-	go startWebSocketServer(framework)
+	framework.Use(
+		d3framework.NewLoggingMiddleware(),
+		d3framework.NewMetricsMiddleware(),
+	)
+
+	framework.RegisterCodec(d3framework.JSONCodec{})
+	framework.RegisterCodec(d3framework.MsgpackCodec{})
+	framework.RegisterCodec(d3framework.ProtobufCodec{})
+
+	framework.Routes("/api", map[string]d3framework.RouteHandler{
+		"/greet": {Data: &CustomDataHandler{}, Delivery: &CustomDeliveryHandler{}},
+	})
+
+	go framework.HTTPServer(":8080")
+	go framework.TCPServer(":8081")
+	go framework.WebSocketServer(":8082")
 
 	select {} // Keep main running
 }
@@ -1,19 +1,25 @@
 package d3framework
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 
 	"golang.org/x/net/websocket"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 // DataHandler is responsible for fetching and managing data
 type DataHandler interface {
-	FetchData(params map[string]string) (interface{}, error)
+	FetchData(ctx context.Context, params map[string]string) (interface{}, error)
 	HandleWebSocketInput(conn *websocket.Conn) (map[string]string, error)
-	SendWebSocketResponse(conn *websocket.Conn, data interface{}) error
+	SendWebSocketResponse(ctx context.Context, conn *websocket.Conn, data interface{}) error
 	HandleTCPInput(conn net.Conn) (map[string]string, error)
 }
 
@@ -39,26 +45,26 @@ func (b *BaseInputHandler) HandleTCPInput(conn net.Conn) (map[string]string, err
 // OutputHandler provides default implementations for output handling
 type BaseOutputHandler struct{}
 
-func (b *BaseOutputHandler) SendHTTPResponse(w http.ResponseWriter, data interface{}) {
+func (b *BaseOutputHandler) SendHTTPResponse(ctx context.Context, w http.ResponseWriter, data interface{}) {
 	http.Error(w, "HTTP response handling not implemented", http.StatusNotImplemented)
 }
-func (b *BaseOutputHandler) SendJSONRPCResponse(w http.ResponseWriter, data interface{}) {
+func (b *BaseOutputHandler) SendJSONRPCResponse(ctx context.Context, w http.ResponseWriter, data interface{}) {
 	http.Error(w, "JSON-RPC response handling not implemented", http.StatusNotImplemented)
 }
-func (b *BaseOutputHandler) SendGRPCResponse(stream grpc.ServerStream, data interface{}) error {
+func (b *BaseOutputHandler) SendGRPCResponse(ctx context.Context, stream grpc.ServerStream, data interface{}) error {
 	return fmt.Errorf("gRPC response handling not implemented")
 }
-func (b *BaseOutputHandler) SendWebSocketResponse(conn *websocket.Conn, data interface{}) error {
+func (b *BaseOutputHandler) SendWebSocketResponse(ctx context.Context, conn *websocket.Conn, data interface{}) error {
 	return fmt.Errorf("WebSocket response handling not implemented")
 }
-func (b *BaseOutputHandler) SendTCPResponse(conn net.Conn, data interface{}) error {
+func (b *BaseOutputHandler) SendTCPResponse(ctx context.Context, conn net.Conn, data interface{}) error {
 	return fmt.Errorf("TCP response handling not implemented")
 }
 
 // DeliveryHandler provides default data processing
 type BaseDeliveryHandler struct{}
 
-func (b *BaseDeliveryHandler) ProcessData(data interface{}) (interface{}, error) {
+func (b *BaseDeliveryHandler) ProcessData(ctx context.Context, data interface{}) (interface{}, error) {
 	return data, nil
 }
 
@@ -79,36 +85,188 @@ type Framework struct {
 	Output   OutputHandler
 	Delivery DeliveryHandler
 	Display  DisplayHandler
+
+	// GRPC is served alongside the REST pipeline by Serve, so a single
+	// Framework can expose both over one port. It is ignored by the
+	// standalone GRPCServer/HTTPServer methods, which keep binding their
+	// own listeners.
+	GRPC *grpc.Server
+
+	middlewares []Middleware
+	gatewayMux  http.Handler
+	codecs      map[string]Codec
+
+	mux *http.ServeMux
+
+	// serverMu guards the fields below: HTTPServer, WebSocketServer,
+	// WebSocketStreamServer, JSONRPCServer, and TCPServer each assign
+	// theirs from a separate goroutine (callers invoke them as `go
+	// framework.XServer(...)`), and Shutdown reads them from whichever
+	// goroutine calls it.
+	serverMu       sync.Mutex
+	httpServer     *http.Server
+	wsServer       *http.Server
+	wsStreamServer *http.Server
+	jsonrpcServer  *http.Server
+	tcpListener    net.Listener
+	closingTCP     atomic.Bool
+}
+
+func (f *Framework) setHTTPServer(s *http.Server) {
+	f.serverMu.Lock()
+	f.httpServer = s
+	f.serverMu.Unlock()
+}
+
+func (f *Framework) setWSServer(s *http.Server) {
+	f.serverMu.Lock()
+	f.wsServer = s
+	f.serverMu.Unlock()
+}
+
+func (f *Framework) setWSStreamServer(s *http.Server) {
+	f.serverMu.Lock()
+	f.wsStreamServer = s
+	f.serverMu.Unlock()
+}
+
+func (f *Framework) setJSONRPCServer(s *http.Server) {
+	f.serverMu.Lock()
+	f.jsonrpcServer = s
+	f.serverMu.Unlock()
+}
+
+func (f *Framework) setTCPListener(l net.Listener) {
+	f.serverMu.Lock()
+	f.tcpListener = l
+	f.serverMu.Unlock()
+}
+
+// runningServers snapshots the fields Shutdown needs under serverMu,
+// so it never touches them concurrently with the setters above.
+func (f *Framework) runningServers() (httpSrv, wsSrv, wsStreamSrv, jsonrpcSrv *http.Server, tcp net.Listener) {
+	f.serverMu.Lock()
+	defer f.serverMu.Unlock()
+	return f.httpServer, f.wsServer, f.wsStreamServer, f.jsonrpcServer, f.tcpListener
+}
+
+// RouteHandler pairs a Data and Delivery pipeline for a single route
+// registered via Framework.Routes.
+type RouteHandler struct {
+	Data     DataHandler
+	Delivery DeliveryHandler
 }
 
 type InputHandler interface {
-	FetchData(params map[string]string) (interface{}, error)
+	FetchData(ctx context.Context, params map[string]string) (interface{}, error)
 	HandleTCPInput(conn net.Conn) (map[string]string, error)
 	HandleHTTPInput(r *http.Request) (map[string]string, error)
 	HandleWebSocketInput(conn *websocket.Conn) (map[string]string, error)
 }
 
 type OutputHandler interface {
-	SendHTTPResponse(w http.ResponseWriter, data interface{})
-	SendTCPResponse(conn net.Conn, data interface{}) error
-	SendWebSocketResponse(conn *websocket.Conn, data interface{}) error
+	SendHTTPResponse(ctx context.Context, w http.ResponseWriter, data interface{})
+	SendTCPResponse(ctx context.Context, conn net.Conn, data interface{}) error
+	SendWebSocketResponse(ctx context.Context, conn *websocket.Conn, data interface{}) error
 }
 type DeliveryHandler interface {
-	ProcessData(data interface{}) (interface{}, error)
+	ProcessData(ctx context.Context, data interface{}) (interface{}, error)
 }
 type DisplayHandler interface {
 	RenderData(data interface{}) (interface{}, error)
 	Display(w http.ResponseWriter, data interface{})
 }
 
-// HTTPServer handles HTTP-specific requests
+// routesMux lazily builds the Framework's own *http.ServeMux, seeded
+// with the catch-all "/" -> ServeHTTP route, so HTTPServer and Serve
+// never touch http.DefaultServeMux.
+func (f *Framework) routesMux() *http.ServeMux {
+	if f.mux == nil {
+		f.mux = http.NewServeMux()
+		f.mux.HandleFunc("/", f.ServeHTTP)
+	}
+	return f.mux
+}
+
+// Routes registers one Handler per path under prefix, each running its
+// own Data/Delivery pipeline through the shared Input/Output handlers
+// and middleware chain. It lets a single Framework expose several
+// endpoints instead of the one catch-all "/" handler.
+func (f *Framework) Routes(prefix string, routes map[string]RouteHandler) {
+	mux := f.routesMux()
+	for path, route := range routes {
+		route := route
+		mux.HandleFunc(prefix+path, func(w http.ResponseWriter, r *http.Request) {
+			f.servePipeline(w, r, route.Data, route.Delivery)
+		})
+	}
+}
+
+// httpRoutesHandler wraps routesMux with every registered HTTPGate, so
+// a signed-cookie (or other pre-upgrade) check runs on plain HTTP
+// requests exactly like it already does ahead of websocket.Handler.
+func (f *Framework) httpRoutesHandler() http.Handler {
+	return f.wrapHTTPGates(f.routesMux())
+}
+
+// HTTPServer handles HTTP-specific requests on its own ServeMux and
+// *http.Server, so multiple Framework instances can run in the same
+// process without fighting over http.DefaultServeMux.
 func (f *Framework) HTTPServer(addr string) {
-	http.HandleFunc("/", f.ServeHTTP)
+	server := &http.Server{Addr: addr, Handler: f.httpRoutesHandler()}
+	f.setHTTPServer(server)
 	fmt.Printf("Starting HTTP server on %s\n", addr)
-	http.ListenAndServe(addr, nil)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("HTTP server error: %v\n", err)
+	}
+}
+
+// NewGRPCServer builds a *grpc.Server with the Framework's middleware
+// chain wired in as a unary interceptor, so Before/After run around
+// every unary RPC exactly as they do for HTTP, WebSocket, and TCP.
+// Register your generated service(s) on the result, then hand it to
+// GRPCServer (or Serve, via Framework.GRPC). Streaming RPCs run the
+// chain via ServeGRPCStream instead, so this only touches unary calls.
+func (f *Framework) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	// ChainUnaryInterceptor, unlike UnaryInterceptor, composes with any
+	// interceptor(s) the caller also passes in opts instead of panicking.
+	opts = append([]grpc.ServerOption{grpc.ChainUnaryInterceptor(f.unaryInterceptor())}, opts...)
+	return grpc.NewServer(opts...)
+}
+
+// unaryInterceptor runs the registered middleware chain's Before/After
+// hooks around a unary RPC. Incoming metadata is exposed to Before as
+// params, one entry per metadata key using its first value, mirroring
+// how HTTP/WebSocket/TCP each expose their own transport's request data
+// as params.
+func (f *Framework) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		params := map[string]string{}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for k, v := range md {
+				if len(v) > 0 {
+					params[k] = v[0]
+				}
+			}
+		}
+		ctx, _, err := f.runBefore(ctx, params)
+		if err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = f.runAfter(ctx, resp)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return resp, nil
+	}
 }
 
-// GRPCServer handles gRPC-specific requests
+// GRPCServer binds addr and serves server, which should normally be
+// built with NewGRPCServer so the middleware chain applies to it.
 func (f *Framework) GRPCServer(addr string, server *grpc.Server) {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -118,23 +276,114 @@ func (f *Framework) GRPCServer(addr string, server *grpc.Server) {
 	fmt.Printf("Starting gRPC server on %s\n", addr)
 	server.Serve(listener)
 }
-func (f *Framework) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+// cookieParam is implemented by middlewares whose Before reads a named
+// param that, on other transports, has to come from an HTTP cookie
+// (currently just AuthMiddleware's CookieName), so withCookieParams
+// knows exactly which cookies it's allowed to forward.
+type cookieParam interface {
+	cookieParamName() string
+}
+
+// withCookieParams copies into params only the cookies that a
+// registered cookieParam middleware actually checks, under their own
+// names, without overwriting anything the Input handler already set.
+// This lets e.g. AuthMiddleware's Before see the same signed token an
+// HTTPGate already validated for this request, without exposing every
+// cookie the client happens to send as an application param.
+func (f *Framework) withCookieParams(params map[string]string, r *http.Request) map[string]string {
+	for _, mw := range f.middlewares {
+		cp, ok := mw.(cookieParam)
+		if !ok {
+			continue
+		}
+		name := cp.cookieParamName()
+		if _, exists := params[name]; exists {
+			continue
+		}
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			continue
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[name] = cookie.Value
+	}
+	return params
+}
+
+// servePipeline runs a single HTTP request through Input -> data ->
+// delivery -> Output, wrapped by the registered middleware chain. It
+// backs both the default ServeHTTP route and every route added via
+// Routes.
+func (f *Framework) servePipeline(w http.ResponseWriter, r *http.Request, data DataHandler, delivery DeliveryHandler) {
+	codec := f.NegotiateCodec(r)
+	ctx := context.WithValue(r.Context(), codecKey{}, codec)
 	params, _ := f.Input.HandleHTTPInput(r)
-	data, _ := f.Data.FetchData(params)
-	processedData, _ := f.Delivery.ProcessData(data)
-	f.Output.SendHTTPResponse(w, processedData)
+	params = f.withCookieParams(params, r)
+	ctx, params, err := f.runBefore(ctx, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	result, _ := data.FetchData(ctx, params)
+	processedData, _ := delivery.ProcessData(ctx, result)
+	processedData, err = f.runAfter(ctx, processedData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", codec.ContentType())
+	f.Output.SendHTTPResponse(ctx, w, processedData)
 }
 
-// WebSocketServer handles WebSocket-specific connections
+func (f *Framework) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.servePipeline(w, r, f.Data, f.Delivery)
+}
+
+// WebSocketServer handles WebSocket-specific connections on its own
+// ServeMux and *http.Server. It negotiates a subprotocol against the
+// codecs registered via RegisterCodec, so a client speaking e.g.
+// "application/x-msgpack" gets that Codec in its connection's context.
 func (f *Framework) WebSocketServer(addr string) {
-	http.Handle("/ws", websocket.Handler(func(conn *websocket.Conn) {
-		params, _ := f.Input.HandleWebSocketInput(conn)
-		data, _ := f.Data.FetchData(params)
-		processedData, _ := f.Delivery.ProcessData(data)
-		f.Output.SendWebSocketResponse(conn, processedData)
-	}))
+	wsHandler := websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			_, protocol := f.NegotiateSubprotocol(config.Protocol)
+			if protocol != "" {
+				config.Protocol = []string{protocol}
+			} else {
+				config.Protocol = nil
+			}
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			codec := f.codecForProtocol(conn.Config().Protocol)
+			ctx := context.WithValue(context.Background(), codecKey{}, codec)
+			params, _ := f.Input.HandleWebSocketInput(conn)
+			ctx, params, err := f.runBefore(ctx, params)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			data, _ := f.Data.FetchData(ctx, params)
+			processedData, _ := f.Delivery.ProcessData(ctx, data)
+			processedData, err = f.runAfter(ctx, processedData)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			f.Output.SendWebSocketResponse(ctx, conn, processedData)
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/ws", f.wrapHTTPGates(wsHandler))
+	server := &http.Server{Addr: addr, Handler: mux}
+	f.setWSServer(server)
 	fmt.Printf("Starting WebSocket server on %s\n", addr)
-	http.ListenAndServe(addr, nil)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("WebSocket server error: %v\n", err)
+	}
 }
 
 // TCPServer handles TCP-specific requests
@@ -144,19 +393,73 @@ func (f *Framework) TCPServer(addr string) {
 		fmt.Printf("Failed to start TCP server: %v\n", err)
 		return
 	}
+	f.setTCPListener(listener)
 	fmt.Printf("Starting TCP server on %s\n", addr)
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if f.closingTCP.Load() {
+				return
+			}
 			fmt.Printf("Failed to accept connection: %v\n", err)
 			continue
 		}
-		go func(conn net.Conn) {
-			params, _ := f.Input.HandleTCPInput(conn)
-			data, _ := f.Data.FetchData(params)
-			processedData, _ := f.Delivery.ProcessData(data)
-			f.Output.SendTCPResponse(conn, processedData)
-			conn.Close()
-		}(conn)
+		go f.handleTCPConn(conn)
+	}
+}
+
+// Shutdown stops whichever of HTTPServer, WebSocketServer, and
+// TCPServer are running: it cancels the TCP Accept loop and gracefully
+// drains the HTTP/WebSocket servers, waiting for in-flight requests to
+// finish or ctx to expire.
+func (f *Framework) Shutdown(ctx context.Context) error {
+	httpSrv, wsSrv, wsStreamSrv, jsonrpcSrv, tcp := f.runningServers()
+
+	if tcp != nil {
+		f.closingTCP.Store(true)
+		if err := tcp.Close(); err != nil {
+			return err
+		}
+	}
+	if httpSrv != nil {
+		if err := httpSrv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if wsSrv != nil {
+		if err := wsSrv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if jsonrpcSrv != nil {
+		if err := jsonrpcSrv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if wsStreamSrv != nil {
+		if err := wsStreamSrv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleTCPConn runs a single TCP connection through the Input -> Data
+// -> Delivery -> Output pipeline. It backs both TCPServer's own accept
+// loop and the raw-TCP fallback listener handed out by Serve.
+func (f *Framework) handleTCPConn(conn net.Conn) {
+	defer conn.Close()
+	ctx := context.Background()
+	params, _ := f.Input.HandleTCPInput(conn)
+	ctx, params, err := f.runBefore(ctx, params)
+	if err != nil {
+		return
+	}
+	data, _ := f.Data.FetchData(ctx, params)
+	processedData, _ := f.Delivery.ProcessData(ctx, data)
+	processedData, err = f.runAfter(ctx, processedData)
+	if err != nil {
+		return
 	}
+	f.Output.SendTCPResponse(ctx, conn, processedData)
 }
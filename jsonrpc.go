@@ -0,0 +1,268 @@
+package d3framework
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+const jsonrpcVersion = "2.0"
+
+// JSON-RPC 2.0 standard error codes.
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+)
+
+// JSONRPCError is the "error" member of a JSON-RPC 2.0 response.
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("jsonrpc: %d %s", e.Code, e.Message)
+}
+
+// jsonrpcRequest is the wire representation of a single JSON-RPC 2.0
+// call. A missing ID marks it as a notification, which produces no
+// response.
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type jsonrpcResponse struct {
+	JSONRPC string
+	Result  interface{}
+	Error   *JSONRPCError
+	ID      json.RawMessage
+}
+
+// MarshalJSON emits exactly one of "result" or "error", as JSON-RPC
+// 2.0 requires: a success response must carry "result" even when its
+// value is nil, and must not carry "error" alongside it.
+func (r jsonrpcResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *JSONRPCError   `json:"error"`
+			ID      json.RawMessage `json:"id,omitempty"`
+		}{r.JSONRPC, r.Error, r.ID})
+	}
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  interface{}     `json:"result"`
+		ID      json.RawMessage `json:"id,omitempty"`
+	}{r.JSONRPC, r.Result, r.ID})
+}
+
+// JSONRPCHandler dispatches JSON-RPC 2.0 requests to a table of
+// methods, each running its own Data/Delivery pipeline through the
+// owning Framework's Input/Output handlers and middleware chain.
+type JSONRPCHandler struct {
+	f       *Framework
+	methods map[string]RouteHandler
+}
+
+// NewJSONRPCHandler builds a JSONRPCHandler that dispatches to
+// methods, keyed by the JSON-RPC "method" field.
+func (f *Framework) NewJSONRPCHandler(methods map[string]RouteHandler) *JSONRPCHandler {
+	return &JSONRPCHandler{f: f, methods: methods}
+}
+
+// decodeParams turns a JSON-RPC "params" object into the
+// map[string]string shape every Data handler in this framework
+// expects. Non-string values are re-encoded as JSON text.
+func decodeParams(raw json.RawMessage) (map[string]string, error) {
+	if len(raw) == 0 {
+		return map[string]string{}, nil
+	}
+	var asMap map[string]interface{}
+	if err := json.Unmarshal(raw, &asMap); err != nil {
+		return nil, err
+	}
+	params := make(map[string]string, len(asMap))
+	for k, v := range asMap {
+		if s, ok := v.(string); ok {
+			params[k] = s
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		params[k] = string(b)
+	}
+	return params, nil
+}
+
+// handleOne runs a single JSON-RPC request through method dispatch and
+// the Data/Delivery pipeline, returning nil for notifications (no ID).
+func (h *JSONRPCHandler) handleOne(ctx context.Context, req jsonrpcRequest) *jsonrpcResponse {
+	respond := func(result interface{}, rpcErr *JSONRPCError) *jsonrpcResponse {
+		if len(req.ID) == 0 {
+			return nil
+		}
+		return &jsonrpcResponse{JSONRPC: jsonrpcVersion, Result: result, Error: rpcErr, ID: req.ID}
+	}
+
+	if req.JSONRPC != jsonrpcVersion || req.Method == "" {
+		return respond(nil, &JSONRPCError{Code: JSONRPCInvalidRequest, Message: "invalid request"})
+	}
+	route, ok := h.methods[req.Method]
+	if !ok {
+		return respond(nil, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: "method not found"})
+	}
+	params, err := decodeParams(req.Params)
+	if err != nil {
+		return respond(nil, &JSONRPCError{Code: JSONRPCInvalidParams, Message: err.Error()})
+	}
+
+	ctx, params, err = h.f.runBefore(ctx, params)
+	if err != nil {
+		return respond(nil, &JSONRPCError{Code: JSONRPCInvalidRequest, Message: err.Error()})
+	}
+	data, err := route.Data.FetchData(ctx, params)
+	if err != nil {
+		return respond(nil, &JSONRPCError{Code: JSONRPCInternalError, Message: err.Error()})
+	}
+	result, err := route.Delivery.ProcessData(ctx, data)
+	if err != nil {
+		return respond(nil, &JSONRPCError{Code: JSONRPCInternalError, Message: err.Error()})
+	}
+	result, err = h.f.runAfter(ctx, result)
+	if err != nil {
+		return respond(nil, &JSONRPCError{Code: JSONRPCInternalError, Message: err.Error()})
+	}
+	return respond(result, nil)
+}
+
+// handle parses body as either a single JSON-RPC request or a batch
+// array and dispatches each one, dropping notifications from the
+// result. It returns (nil, nil) when nothing should be written back
+// (an all-notification batch).
+func (h *JSONRPCHandler) handle(ctx context.Context, body []byte) (interface{}, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, &JSONRPCError{Code: JSONRPCInvalidRequest, Message: "empty request"}
+	}
+
+	if trimmed[0] == '[' {
+		var reqs []jsonrpcRequest
+		if err := json.Unmarshal(trimmed, &reqs); err != nil {
+			return nil, &JSONRPCError{Code: JSONRPCParseError, Message: err.Error()}
+		}
+		if len(reqs) == 0 {
+			return nil, &JSONRPCError{Code: JSONRPCInvalidRequest, Message: "empty batch"}
+		}
+		responses := make([]*jsonrpcResponse, 0, len(reqs))
+		for _, req := range reqs {
+			if resp := h.handleOne(ctx, req); resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			return nil, nil
+		}
+		return responses, nil
+	}
+
+	var req jsonrpcRequest
+	if err := json.Unmarshal(trimmed, &req); err != nil {
+		return nil, &JSONRPCError{Code: JSONRPCParseError, Message: err.Error()}
+	}
+	// handleOne returns a nil *jsonrpcResponse for a notification;
+	// boxing that directly into the interface{} return would produce a
+	// non-nil interface holding a nil pointer, so the `result == nil`
+	// checks in ServeHTTP/WebSocketHandler would never see it as "no
+	// response".
+	if resp := h.handleOne(ctx, req); resp != nil {
+		return resp, nil
+	}
+	return nil, nil
+}
+
+// ServeHTTP lets JSONRPCHandler be mounted directly on an
+// *http.ServeMux for the JSON-RPC-over-HTTP-POST transport.
+func (h *JSONRPCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.handle(r.Context(), body)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		rpcErr, ok := err.(*JSONRPCError)
+		if !ok {
+			rpcErr = &JSONRPCError{Code: JSONRPCInternalError, Message: err.Error()}
+		}
+		json.NewEncoder(w).Encode(jsonrpcResponse{JSONRPC: jsonrpcVersion, Error: rpcErr})
+		return
+	}
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// WebSocketHandler returns a websocket.Handler that pipes each text
+// frame received through the same dispatch ServeHTTP uses, letting
+// browser clients pipeline JSON-RPC calls over one persistent socket.
+func (h *JSONRPCHandler) WebSocketHandler() websocket.Handler {
+	return func(conn *websocket.Conn) {
+		for {
+			var body string
+			if err := websocket.Message.Receive(conn, &body); err != nil {
+				return
+			}
+			result, err := h.handle(context.Background(), []byte(body))
+			if err != nil {
+				rpcErr, ok := err.(*JSONRPCError)
+				if !ok {
+					rpcErr = &JSONRPCError{Code: JSONRPCInternalError, Message: err.Error()}
+				}
+				if sendErr := websocket.JSON.Send(conn, jsonrpcResponse{JSONRPC: jsonrpcVersion, Error: rpcErr}); sendErr != nil {
+					return
+				}
+				continue
+			}
+			if result == nil {
+				continue
+			}
+			if sendErr := websocket.JSON.Send(conn, result); sendErr != nil {
+				return
+			}
+		}
+	}
+}
+
+// JSONRPCServer starts a JSON-RPC 2.0 server on addr: POST requests at
+// "/" and WebSocket connections at "/ws" both dispatch to methods.
+func (f *Framework) JSONRPCServer(addr string, methods map[string]RouteHandler) {
+	handler := f.NewJSONRPCHandler(methods)
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	mux.Handle("/ws", handler.WebSocketHandler())
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	f.setJSONRPCServer(server)
+	fmt.Printf("Starting JSON-RPC server on %s\n", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("JSON-RPC server error: %v\n", err)
+	}
+}
@@ -0,0 +1,252 @@
+package d3framework
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Middleware lets callers hook into every transport's Input -> Data ->
+// Delivery -> Output pipeline to add cross-cutting concerns such as
+// auth, logging, rate-limiting, metrics, or tracing without touching
+// the Data/Delivery handlers themselves.
+type Middleware interface {
+	// Before runs after input parsing and before FetchData. It may
+	// enrich the context, add or rewrite params, or reject the request
+	// outright by returning a non-nil error.
+	Before(ctx context.Context, params map[string]string) (context.Context, map[string]string, error)
+	// After runs once Delivery has produced data, letting a middleware
+	// transform or inspect the result before it reaches the Output
+	// handler.
+	After(ctx context.Context, data interface{}) (interface{}, error)
+}
+
+// Use registers middlewares on the framework. They run, in registration
+// order, before every HTTP, WebSocket, and TCP request, and in reverse
+// order on the way out.
+func (f *Framework) Use(mw ...Middleware) {
+	f.middlewares = append(f.middlewares, mw...)
+}
+
+// HTTPGate is implemented by middlewares that must act before an
+// HTTP-level handshake completes — most notably rejecting a WebSocket
+// upgrade outright — rather than only via Before/After once the
+// pipeline is already running on an established connection.
+type HTTPGate interface {
+	WrapHTTP(next http.Handler) http.Handler
+}
+
+// ErrorObserver is implemented by middlewares that want to react when
+// the Before/After chain is aborted by an error, e.g. to increment an
+// error counter.
+type ErrorObserver interface {
+	OnError(ctx context.Context, err error)
+}
+
+// runBefore runs the Before hook of every registered middleware in
+// order, threading the context and params through each one. It stops
+// and returns the error from the first middleware that rejects the
+// request.
+func (f *Framework) runBefore(ctx context.Context, params map[string]string) (context.Context, map[string]string, error) {
+	var err error
+	for _, mw := range f.middlewares {
+		ctx, params, err = mw.Before(ctx, params)
+		if err != nil {
+			f.notifyError(ctx, err)
+			return ctx, params, err
+		}
+	}
+	return ctx, params, nil
+}
+
+// runAfter runs the After hook of every registered middleware in
+// reverse registration order, so the first middleware to see a request
+// is the last to see its response.
+func (f *Framework) runAfter(ctx context.Context, data interface{}) (interface{}, error) {
+	var err error
+	for i := len(f.middlewares) - 1; i >= 0; i-- {
+		data, err = f.middlewares[i].After(ctx, data)
+		if err != nil {
+			f.notifyError(ctx, err)
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// notifyError tells every registered ErrorObserver that the chain was
+// aborted by err.
+func (f *Framework) notifyError(ctx context.Context, err error) {
+	for _, mw := range f.middlewares {
+		if observer, ok := mw.(ErrorObserver); ok {
+			observer.OnError(ctx, err)
+		}
+	}
+}
+
+// wrapHTTPGates wraps handler with the WrapHTTP of every registered
+// HTTPGate middleware, so a missing or invalid auth cookie rejects the
+// request before websocket.Handler performs the upgrade handshake.
+func (f *Framework) wrapHTTPGates(handler http.Handler) http.Handler {
+	for i := len(f.middlewares) - 1; i >= 0; i-- {
+		if gate, ok := f.middlewares[i].(HTTPGate); ok {
+			handler = gate.WrapHTTP(handler)
+		}
+	}
+	return handler
+}
+
+// AuthMiddleware rejects requests that don't carry a signed token in
+// the CookieName cookie, following the challenge/response cookie
+// pattern from the WebSocket auth examples. As an HTTPGate it rejects
+// a WebSocket connection before the upgrade handshake; its Before also
+// checks params[CookieName] for transports with no HTTP cookie, such
+// as TCP.
+type AuthMiddleware struct {
+	CookieName string
+	Secret     []byte
+	Tokens     map[string]bool
+}
+
+// NewAuthMiddleware builds an AuthMiddleware that accepts any of the
+// given tokens, signed with secret, via a cookie/param named
+// cookieName.
+func NewAuthMiddleware(secret []byte, cookieName string, validTokens ...string) *AuthMiddleware {
+	tokens := make(map[string]bool, len(validTokens))
+	for _, t := range validTokens {
+		tokens[t] = true
+	}
+	return &AuthMiddleware{CookieName: cookieName, Secret: secret, Tokens: tokens}
+}
+
+// Sign produces the cookie value for token: "<token>.<hex hmac-sha256>".
+func (a *AuthMiddleware) Sign(token string) string {
+	return token + "." + hex.EncodeToString(a.mac(token))
+}
+
+func (a *AuthMiddleware) mac(token string) []byte {
+	h := hmac.New(sha256.New, a.Secret)
+	h.Write([]byte(token))
+	return h.Sum(nil)
+}
+
+// verify checks that signed is "<token>.<hmac>" for one of the
+// configured Tokens, signed with Secret.
+func (a *AuthMiddleware) verify(signed string) bool {
+	sep := strings.LastIndex(signed, ".")
+	if sep < 0 {
+		return false
+	}
+	token, sig := signed[:sep], signed[sep+1:]
+	if !a.Tokens[token] {
+		return false
+	}
+	expected, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, a.mac(token))
+}
+
+// WrapHTTP rejects the request with 401 before next (typically a
+// websocket.Handler) runs, unless CookieName carries a validly signed
+// token, so an unauthenticated client never completes the WebSocket
+// upgrade.
+func (a *AuthMiddleware) WrapHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(a.CookieName)
+		if err != nil || !a.verify(cookie.Value) {
+			http.Error(w, fmt.Sprintf("auth: missing or invalid %s", a.CookieName), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cookieParamName implements the framework's cookieParam interface, so
+// servePipeline knows to forward the CookieName cookie into params for
+// Before to check.
+func (a *AuthMiddleware) cookieParamName() string { return a.CookieName }
+
+func (a *AuthMiddleware) Before(ctx context.Context, params map[string]string) (context.Context, map[string]string, error) {
+	if !a.verify(params[a.CookieName]) {
+		return ctx, params, fmt.Errorf("auth: missing or invalid %s", a.CookieName)
+	}
+	return ctx, params, nil
+}
+
+func (a *AuthMiddleware) After(ctx context.Context, data interface{}) (interface{}, error) {
+	return data, nil
+}
+
+// LoggingMiddleware writes a structured line for every request's
+// params on the way in and elapsed time on the way out.
+type LoggingMiddleware struct {
+	Logger *log.Logger
+}
+
+// NewLoggingMiddleware builds a LoggingMiddleware that writes to the
+// standard logger.
+func NewLoggingMiddleware() *LoggingMiddleware {
+	return &LoggingMiddleware{Logger: log.Default()}
+}
+
+type loggingStartKey struct{}
+
+func (l *LoggingMiddleware) Before(ctx context.Context, params map[string]string) (context.Context, map[string]string, error) {
+	ctx = context.WithValue(ctx, loggingStartKey{}, time.Now())
+	l.Logger.Printf("request params=%v", params)
+	return ctx, params, nil
+}
+
+func (l *LoggingMiddleware) After(ctx context.Context, data interface{}) (interface{}, error) {
+	if start, ok := ctx.Value(loggingStartKey{}).(time.Time); ok {
+		l.Logger.Printf("response elapsed=%s", time.Since(start))
+	}
+	return data, nil
+}
+
+// MetricsMiddleware keeps prometheus-style request/error counters. It
+// exposes them via ServeHTTP in the Prometheus text exposition format
+// so it can be mounted as its own handler (e.g. at /metrics).
+type MetricsMiddleware struct {
+	requests atomic.Int64
+	errors   atomic.Int64
+}
+
+// NewMetricsMiddleware builds an empty MetricsMiddleware.
+func NewMetricsMiddleware() *MetricsMiddleware {
+	return &MetricsMiddleware{}
+}
+
+func (m *MetricsMiddleware) Before(ctx context.Context, params map[string]string) (context.Context, map[string]string, error) {
+	m.requests.Add(1)
+	return ctx, params, nil
+}
+
+func (m *MetricsMiddleware) After(ctx context.Context, data interface{}) (interface{}, error) {
+	return data, nil
+}
+
+// OnError implements ErrorObserver, counting every Before/After
+// rejection the pipeline reports.
+func (m *MetricsMiddleware) OnError(ctx context.Context, err error) {
+	m.errors.Add(1)
+}
+
+// ServeHTTP renders the current counters in the Prometheus text
+// exposition format.
+func (m *MetricsMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# TYPE d3framework_requests_total counter\n")
+	fmt.Fprintf(w, "d3framework_requests_total %d\n", m.requests.Load())
+	fmt.Fprintf(w, "# TYPE d3framework_errors_total counter\n")
+	fmt.Fprintf(w, "d3framework_errors_total %d\n", m.errors.Load())
+}
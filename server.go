@@ -0,0 +1,81 @@
+package d3framework
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/soheilhy/cmux"
+)
+
+// Transcode mounts a grpc-gateway (or any other http.Handler) in front
+// of the REST pipeline exposed by Serve, so a single service definition
+// can be reached simultaneously as JSON/HTTP and gRPC. Requests that
+// don't match gatewayMux's registered routes fall through to the
+// Framework's own ServeHTTP.
+func (f *Framework) Transcode(gatewayMux http.Handler) {
+	f.gatewayMux = gatewayMux
+}
+
+// Serve opens a single net.Listener on addr and multiplexes HTTP/1.1,
+// gRPC (HTTP/2 with the grpc content-type), and raw TCP traffic over
+// it, so HTTPServer, GRPCServer, and TCPServer no longer need three
+// separate ports. Set Framework.GRPC before calling Serve to have gRPC
+// traffic dispatched to it; set a gateway via Transcode to expose the
+// same routes as REST.
+func (f *Framework) Serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("d3framework: failed to listen on %s: %w", addr, err)
+	}
+
+	m := cmux.New(listener)
+	// HTTP2MatchHeaderFieldSendSettings (rather than plain
+	// HTTP2HeaderField) acks the client's SETTINGS frame as soon as the
+	// content-type header is seen, so a gRPC client's connection goes
+	// READY without first having to send an actual RPC's HEADERS frame.
+	grpcListener := m.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
+	httpListener := m.Match(cmux.HTTP1Fast(), cmux.HTTP2())
+	tcpListener := m.Match(cmux.Any())
+
+	if f.GRPC != nil {
+		go func() {
+			if err := f.GRPC.Serve(grpcListener); err != nil {
+				fmt.Printf("gRPC serve error: %v\n", err)
+			}
+		}()
+	}
+
+	httpServer := &http.Server{Handler: f.httpHandler()}
+	go func() {
+		if err := httpServer.Serve(httpListener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("HTTP serve error: %v\n", err)
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := tcpListener.Accept()
+			if err != nil {
+				return
+			}
+			go f.handleTCPConn(conn)
+		}
+	}()
+
+	fmt.Printf("Starting multiplexed server on %s\n", addr)
+	return m.Serve()
+}
+
+// httpHandler returns the handler Serve registers for the HTTP/1.1 and
+// HTTP/2-without-grpc listener: the gateway mux if Transcode was used,
+// falling back to the Framework's own ServeHTTP for unmatched routes.
+func (f *Framework) httpHandler() http.Handler {
+	if f.gatewayMux == nil {
+		return f.httpRoutesHandler()
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", f.wrapHTTPGates(f.gatewayMux))
+	mux.Handle("/d3/", f.httpRoutesHandler())
+	return mux
+}
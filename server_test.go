@@ -0,0 +1,122 @@
+package d3framework
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testDataHandler answers FetchData with a fixed value; it embeds both
+// bases so the same value satisfies DataHandler and InputHandler,
+// which this test drives only over HTTP.
+type testDataHandler struct {
+	BaseInputHandler
+	BaseOutputHandler
+}
+
+func (testDataHandler) FetchData(ctx context.Context, params map[string]string) (interface{}, error) {
+	return "pong", nil
+}
+
+// testOutputHandler writes FetchData's result as the HTTP body.
+type testOutputHandler struct{ BaseOutputHandler }
+
+func (testOutputHandler) SendHTTPResponse(ctx context.Context, w http.ResponseWriter, data interface{}) {
+	fmt.Fprint(w, data)
+}
+
+// TestServeMultiplexesHTTPAndGRPC drives a REST call and a gRPC call
+// over the single listener Serve opens, verifying both protocols are
+// actually reachable on the same port rather than just configured.
+func TestServeMultiplexesHTTPAndGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	grpcServer := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(grpcServer, healthServer)
+
+	f := &Framework{
+		Data:     &testDataHandler{},
+		Input:    &testDataHandler{},
+		Output:   &testOutputHandler{},
+		Delivery: &BaseDeliveryHandler{},
+		GRPC:     grpcServer,
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- f.Serve(addr) }()
+	defer grpcServer.Stop()
+
+	waitForListener(t, addr)
+
+	t.Run("REST", func(t *testing.T) {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		if string(body) != "pong" {
+			t.Fatalf("body = %q, want %q", body, "pong")
+		}
+	})
+
+	t.Run("gRPC", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+
+		client := grpc_health_v1.NewHealthClient(conn)
+		resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		if err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+		if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+			t.Fatalf("status = %v, want SERVING", resp.Status)
+		}
+	})
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("Serve returned early: %v", err)
+	default:
+	}
+}
+
+// waitForListener polls addr until something accepts connections, so
+// the test's HTTP/gRPC calls don't race Serve's listener setup.
+func waitForListener(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("nothing listening on %s", addr)
+}
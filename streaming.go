@@ -0,0 +1,183 @@
+package d3framework
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+)
+
+// StreamingDataHandler is implemented by Data handlers that push a
+// series of values over time instead of returning a single result, for
+// server-push use cases such as live dashboards, event feeds, or gRPC
+// server-streaming RPCs. The returned channel is closed by the handler
+// once it has nothing more to send.
+type StreamingDataHandler interface {
+	Stream(ctx context.Context, params map[string]string) (<-chan interface{}, error)
+}
+
+// WebSocketStreamServer accepts WebSocket connections at /ws and, for
+// each one, runs every item from stream.Stream through
+// Delivery.ProcessData and Output.SendWebSocketResponse until the
+// client disconnects or the connection's context is cancelled.
+func (f *Framework) WebSocketStreamServer(addr string, stream StreamingDataHandler) {
+	wsHandler := websocket.Handler(func(conn *websocket.Conn) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		params, _ := f.Input.HandleWebSocketInput(conn)
+		ctx, params, err := f.runBefore(ctx, params)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		items, err := stream.Stream(ctx, params)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		for item := range items {
+			processedData, err := f.Delivery.ProcessData(ctx, item)
+			if err != nil {
+				return
+			}
+			processedData, err = f.runAfter(ctx, processedData)
+			if err != nil {
+				return
+			}
+			if err := f.Output.SendWebSocketResponse(ctx, conn, processedData); err != nil {
+				return
+			}
+		}
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", f.wrapHTTPGates(wsHandler))
+	server := &http.Server{Addr: addr, Handler: mux}
+	f.setWSStreamServer(server)
+	fmt.Printf("Starting WebSocket stream server on %s\n", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("WebSocket stream server error: %v\n", err)
+	}
+}
+
+// GRPCStreamServer binds addr and serves server, exactly like
+// GRPCServer. Registered server-streaming RPC handlers call
+// Framework.ServeGRPCStream to drive a StreamingDataHandler through
+// Delivery and onto the RPC's stream.
+func (f *Framework) GRPCStreamServer(addr string, server *grpc.Server) {
+	f.GRPCServer(addr, server)
+}
+
+// ServeGRPCStream drives a streaming RPC handler: it fetches a channel
+// from data.Stream and runs every item through Delivery.ProcessData
+// before sending it via serverStream.SendMsg, until the channel closes
+// or ctx is cancelled.
+func (f *Framework) ServeGRPCStream(ctx context.Context, serverStream grpc.ServerStream, params map[string]string, data StreamingDataHandler) error {
+	ctx, params, err := f.runBefore(ctx, params)
+	if err != nil {
+		return err
+	}
+	items, err := data.Stream(ctx, params)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case item, ok := <-items:
+			if !ok {
+				return nil
+			}
+			processedData, err := f.Delivery.ProcessData(ctx, item)
+			if err != nil {
+				return err
+			}
+			processedData, err = f.runAfter(ctx, processedData)
+			if err != nil {
+				return err
+			}
+			if err := serverStream.SendMsg(processedData); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Broker fans the values published by one producer out to any number
+// of subscribers, so a single upstream fetch can drive N connected
+// clients. It satisfies StreamingDataHandler itself, so it can be
+// handed directly to WebSocketStreamServer or ServeGRPCStream.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan interface{}]struct{}
+}
+
+// NewBroker builds an empty Broker.
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[chan interface{}]struct{})}
+}
+
+// Subscribe registers a new subscriber channel. Call the returned
+// function to unsubscribe and release it once the caller is done.
+func (b *Broker) Subscribe() (<-chan interface{}, func()) {
+	ch := make(chan interface{}, 16)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// Publish fans data out to every current subscriber. A subscriber
+// whose buffer is full is skipped for this item rather than blocking
+// the publisher.
+func (b *Broker) Publish(data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+// Stream implements StreamingDataHandler by subscribing to the Broker
+// for the lifetime of ctx, ignoring params since a Broker has no
+// per-subscriber filtering.
+func (b *Broker) Stream(ctx context.Context, params map[string]string) (<-chan interface{}, error) {
+	sub, unsubscribe := b.Subscribe()
+	out := make(chan interface{})
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item, ok := <-sub:
+				if !ok {
+					return
+				}
+				select {
+				case out <- item:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}